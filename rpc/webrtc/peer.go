@@ -2,12 +2,19 @@ package rpcwebrtc
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
 	"io"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/edaniels/golog"
 	"github.com/pion/interceptor"
 	"github.com/pion/webrtc/v3"
+	"github.com/pkg/errors"
 	"go.uber.org/multierr"
 
 	"go.viam.com/utils"
@@ -51,7 +58,26 @@ func newWebRTCAPI(logger golog.Logger) (*webrtc.API, error) {
 	return webrtc.NewAPI(options...), nil
 }
 
-func newPeerConnectionForClient(ctx context.Context, config webrtc.Configuration, disableTrickle bool, logger golog.Logger) (pc *webrtc.PeerConnection, dc *webrtc.DataChannel, err error) {
+// NewPeerConnectionForClient builds a PeerConnection and its negotiated data channel for
+// the client side of a WebRTC dial. If stunPool is non-nil, it is consulted for a
+// probed, batched set of ICE servers that takes precedence over config.ICEServers.
+func NewPeerConnectionForClient(
+	ctx context.Context,
+	config webrtc.Configuration,
+	stunPool *STUNPool,
+	disableTrickle bool,
+	logger golog.Logger,
+) (pc *webrtc.PeerConnection, dc *webrtc.DataChannel, err error) {
+	if stunPool != nil {
+		iceServers, err := stunPool.ICEServers(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(iceServers) > 0 {
+			config.ICEServers = mergeSTUNServers(config.ICEServers, iceServers)
+		}
+	}
+
 	webAPI, err := newWebRTCAPI(logger)
 	if err != nil {
 		return nil, nil, err
@@ -111,7 +137,28 @@ func newPeerConnectionForClient(ctx context.Context, config webrtc.Configuration
 	return pc, dataChannel, nil
 }
 
-func newPeerConnectionForServer(ctx context.Context, sdp string, config webrtc.Configuration, disableTrickle bool, logger golog.Logger) (pc *webrtc.PeerConnection, dc *webrtc.DataChannel, err error) {
+// NewPeerConnectionForServer builds a PeerConnection and its negotiated data channel for
+// the server side of a WebRTC dial, answering the given offer sdp. If stunPool is
+// non-nil, it is consulted for a probed, batched set of ICE servers that takes
+// precedence over config.ICEServers.
+func NewPeerConnectionForServer(
+	ctx context.Context,
+	sdp string,
+	config webrtc.Configuration,
+	stunPool *STUNPool,
+	disableTrickle bool,
+	logger golog.Logger,
+) (pc *webrtc.PeerConnection, dc *webrtc.DataChannel, err error) {
+	if stunPool != nil {
+		iceServers, err := stunPool.ICEServers(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(iceServers) > 0 {
+			config.ICEServers = mergeSTUNServers(config.ICEServers, iceServers)
+		}
+	}
+
 	webAPI, err := newWebRTCAPI(logger)
 	if err != nil {
 		return nil, nil, err
@@ -178,6 +225,180 @@ func newPeerConnectionForServer(ctx context.Context, sdp string, config webrtc.C
 	return pc, dataChannel, nil
 }
 
+// HolePunchConfig carries the pre-exchanged ICE credentials two private peers agreed on
+// through the signaling server, so both sides can build a PeerConnection with a
+// matching ufrag/pwd pair and fire their first STUN Binding Requests without either
+// side acting as the offerer.
+type HolePunchConfig struct {
+	LocalUFrag  string
+	LocalPwd    string
+	RemoteUFrag string
+	RemotePwd   string
+
+	// LocalCert is the certificate whose fingerprint was already advertised to the
+	// remote peer via signaling (see GenerateHolePunchCertificate). The PeerConnection
+	// must be built with this exact certificate so the fingerprint it actually presents
+	// during the DTLS handshake matches what the remote peer was told to expect.
+	LocalCert *webrtc.Certificate
+
+	// RemoteFingerprintAlgorithm and RemoteFingerprintValue identify the remote peer's
+	// DTLS certificate, as advertised through signaling, so the synthesized remote
+	// description can carry the genuine remote fingerprint instead of our own.
+	RemoteFingerprintAlgorithm string
+	RemoteFingerprintValue     string
+
+	// RemoteReflexiveIP and RemoteReflexivePort are the remote peer's server-reflexive
+	// address, as observed by the remote peer's own STUN probe (see
+	// DiscoverReflexiveAddr) and advertised through signaling. Without a target address,
+	// the synchronized start time alone gives the ICE agent nothing to send its first
+	// Binding Requests to, so these are fed in as a remote ICE candidate.
+	RemoteReflexiveIP   string
+	RemoteReflexivePort int
+}
+
+// GenerateHolePunchCertificate creates a fresh self-signed DTLS certificate and returns
+// it alongside its fingerprint. Generating the certificate up front, before the
+// PeerConnection exists, lets its fingerprint be advertised to the remote peer through
+// signaling (e.g. in RegisterPeerRequest) in time to build a correct remote description
+// with NewPeerConnectionForHolePunch.
+func GenerateHolePunchCertificate() (cert *webrtc.Certificate, fingerprintAlgorithm, fingerprintValue string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", "", err
+	}
+	cert, err = webrtc.GenerateCertificate(key)
+	if err != nil {
+		return nil, "", "", err
+	}
+	fingerprints, err := cert.GetFingerprints()
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(fingerprints) == 0 {
+		return nil, "", "", errors.New("generated certificate reported no fingerprints")
+	}
+	return cert, fingerprints[0].Algorithm, fingerprints[0].Value, nil
+}
+
+// NewPeerConnectionForHolePunch builds a PeerConnection for the hole-punch mode of
+// DialWebRTCOptions: rather than running a real offer/answer exchange, both peers force
+// their local ICE credentials to the pre-agreed values in hp so their first Binding
+// Requests can cross in-flight at the synchronized start time coordinated by the
+// signaling server, and their DTLS certificate/fingerprint pair (see
+// GenerateHolePunchCertificate) so each side's synthesized remote description describes
+// the genuine remote certificate rather than its own.
+func NewPeerConnectionForHolePunch(ctx context.Context, config webrtc.Configuration, hp HolePunchConfig, logger golog.Logger) (pc *webrtc.PeerConnection, dc *webrtc.DataChannel, err error) {
+	settingEngine := webrtc.SettingEngine{}
+	if utils.Debug {
+		settingEngine.LoggerFactory = LoggerFactory{logger}
+	}
+	if err := settingEngine.SetICECredentials(hp.LocalUFrag, hp.LocalPwd); err != nil {
+		return nil, nil, err
+	}
+
+	if hp.LocalCert != nil {
+		config.Certificates = []webrtc.Certificate{*hp.LocalCert}
+	}
+
+	m := webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, err
+	}
+	i := interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(&m, &i); err != nil {
+		return nil, nil, err
+	}
+	webAPI := webrtc.NewAPI(webrtc.WithMediaEngine(&m), webrtc.WithInterceptorRegistry(&i), webrtc.WithSettingEngine(settingEngine))
+
+	pc, err = webAPI.NewPeerConnection(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	var successful bool
+	defer func() {
+		if !successful {
+			err = multierr.Combine(err, pc.Close())
+		}
+	}()
+
+	negotiated := true
+	ordered := true
+	dataChannelID := uint16(0)
+	dataChannel, err := pc.CreateDataChannel("data", &webrtc.DataChannelInit{
+		ID:         &dataChannelID,
+		Negotiated: &negotiated,
+		Ordered:    &ordered,
+	})
+	if err != nil {
+		return pc, nil, err
+	}
+	dataChannel.OnError(initialDataChannelOnError(pc, logger))
+
+	// Each side synthesizes its own offer/answer pair locally from the pre-exchanged
+	// ufrag/pwd rather than running a signaling round trip, keeping the simultaneous
+	// open on schedule.
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return pc, dataChannel, err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return pc, dataChannel, err
+	}
+
+	remoteOffer := offer
+	remoteOffer.SDP = replaceICECredentials(offer.SDP, hp.RemoteUFrag, hp.RemotePwd)
+	remoteOffer.SDP = replaceFingerprint(remoteOffer.SDP, hp.RemoteFingerprintAlgorithm, hp.RemoteFingerprintValue)
+	if err := pc.SetRemoteDescription(remoteOffer); err != nil {
+		return pc, dataChannel, err
+	}
+
+	// The synthesized remote description above carries none of the remote peer's real
+	// candidates, so without this the ICE agent has no target address to send its first
+	// Binding Requests to and the synchronized start time alone cannot open a pinhole.
+	if hp.RemoteReflexiveIP != "" {
+		cand := reflexiveICECandidate(hp.RemoteReflexiveIP, hp.RemoteReflexivePort)
+		if err := pc.AddICECandidate(webrtc.ICECandidateInit{Candidate: cand}); err != nil {
+			return pc, dataChannel, err
+		}
+	}
+
+	successful = true
+	return pc, dataChannel, nil
+}
+
+// reflexiveICECandidate builds a raw srflx ICE candidate string for ip/port, for use
+// with pc.AddICECandidate to feed in a remote peer's externally-observed address in the
+// hole-punch dial path, where no real offer/answer exchange produces real candidates.
+func reflexiveICECandidate(ip string, port int) string {
+	return fmt.Sprintf("candidate:1 1 udp 1 %s %d typ srflx raddr 0.0.0.0 rport 0", ip, port)
+}
+
+var iceCredentialLineRegexp = regexp.MustCompile(`(?m)^a=ice-(ufrag|pwd):.*$`)
+
+// replaceICECredentials rewrites the a=ice-ufrag/a=ice-pwd lines of an SDP so a locally
+// synthesized offer can stand in as the remote peer's description.
+func replaceICECredentials(sdp, ufrag, pwd string) string {
+	return iceCredentialLineRegexp.ReplaceAllStringFunc(sdp, func(line string) string {
+		if strings.HasPrefix(line, "a=ice-ufrag:") {
+			return "a=ice-ufrag:" + ufrag
+		}
+		return "a=ice-pwd:" + pwd
+	})
+}
+
+var fingerprintLineRegexp = regexp.MustCompile(`(?m)^a=fingerprint:.*$`)
+
+// replaceFingerprint rewrites the a=fingerprint line of an SDP to describe the remote
+// peer's real DTLS certificate instead of the local peer's own, which is what a
+// locally-synthesized offer would otherwise contain. A blank algorithm or value leaves
+// the SDP untouched.
+func replaceFingerprint(sdp, algorithm, value string) string {
+	if algorithm == "" || value == "" {
+		return sdp
+	}
+	return fingerprintLineRegexp.ReplaceAllString(sdp, "a=fingerprint:"+algorithm+" "+value)
+}
+
 type peerConnectionStats struct {
 	ID               string
 	RemoteCandidates map[string]string
@@ -0,0 +1,11 @@
+package rpcwebrtc
+
+import "testing"
+
+func TestReflexiveICECandidateFormat(t *testing.T) {
+	cand := reflexiveICECandidate("203.0.113.5", 54321)
+	want := "candidate:1 1 udp 1 203.0.113.5 54321 typ srflx raddr 0.0.0.0 rport 0"
+	if cand != want {
+		t.Fatalf("reflexiveICECandidate() = %q, want %q", cand, want)
+	}
+}
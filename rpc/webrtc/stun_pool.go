@@ -0,0 +1,307 @@
+package rpcwebrtc
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/webrtc/v3"
+	"github.com/pkg/errors"
+)
+
+// DefaultSTUNProbeTimeout bounds how long a single STUN Binding Request probe is
+// allowed to take before its candidate is considered unreachable.
+const DefaultSTUNProbeTimeout = 2 * time.Second
+
+// DefaultSTUNProbeCacheTTL is how long a probe result is reused before its candidate is
+// re-probed on a subsequent call to STUNPool.ICEServers.
+const DefaultSTUNProbeCacheTTL = 5 * time.Minute
+
+// ProbeFunc measures whether a STUN server is reachable and, if so, how long a Binding
+// Request round trip took.
+type ProbeFunc func(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool)
+
+// STUNPool selects the fastest reachable subset of a larger candidate list of STUN
+// servers, so a single dead entry (e.g. the hard-coded Twilio STUN server going down)
+// cannot take out every WebRTC dial in a deployment. Probe results are cached for
+// CacheTTL so repeated dials do not re-probe every candidate each time.
+type STUNPool struct {
+	// Candidates is the full list of STUN servers to consider.
+	Candidates []webrtc.ICEServer
+	// BatchSize is how many of the fastest reachable Candidates to return. A value <= 0
+	// returns every reachable candidate.
+	BatchSize int
+	// Probe measures reachability and RTT for a single candidate. Defaults to
+	// DefaultSTUNProbe if nil.
+	Probe ProbeFunc
+	// CacheTTL is how long a probe result is reused. Defaults to DefaultSTUNProbeCacheTTL
+	// if <= 0.
+	CacheTTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]stunProbeResult
+}
+
+type stunProbeResult struct {
+	rtt       time.Duration
+	reachable bool
+	probedAt  time.Time
+}
+
+// NewSTUNPool returns a STUNPool over candidates that returns the fastest batchSize of
+// them, reachability permitting, on each call to ICEServers. A nil probe defaults to
+// DefaultSTUNProbe.
+func NewSTUNPool(candidates []webrtc.ICEServer, batchSize int, probe ProbeFunc) *STUNPool {
+	if probe == nil {
+		probe = DefaultSTUNProbe
+	}
+	return &STUNPool{
+		Candidates: candidates,
+		BatchSize:  batchSize,
+		Probe:      probe,
+		CacheTTL:   DefaultSTUNProbeCacheTTL,
+		cache:      map[string]stunProbeResult{},
+	}
+}
+
+// DefaultSTUNPool is the default pool of public STUN servers probed for reachability
+// before each WebRTC dial. It replaces relying on a single, single-point-of-failure
+// STUN server for every connection.
+var DefaultSTUNPool = NewSTUNPool([]webrtc.ICEServer{
+	{URLs: []string{"stun:global.stun.twilio.com:3478?transport=udp"}},
+	{URLs: []string{"stun:stun.l.google.com:19302"}},
+	{URLs: []string{"stun:stun1.l.google.com:19302"}},
+	{URLs: []string{"stun:stun2.l.google.com:19302"}},
+}, 3, nil)
+
+// ICEServers concurrently probes every candidate not already cached within CacheTTL,
+// discards the unreachable ones, and returns the fastest BatchSize as the effective ICE
+// servers to use for a connection attempt.
+func (p *STUNPool) ICEServers(ctx context.Context) ([]webrtc.ICEServer, error) {
+	type scored struct {
+		server webrtc.ICEServer
+		rtt    time.Duration
+	}
+
+	results := make([]scored, 0, len(p.Candidates))
+	var resultsMu sync.Mutex
+	var wg sync.WaitGroup
+	for _, server := range p.Candidates {
+		server := server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, reachable := p.probeCached(ctx, server)
+			if !reachable {
+				return
+			}
+			resultsMu.Lock()
+			results = append(results, scored{server, rtt})
+			resultsMu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].rtt < results[j].rtt })
+
+	batchSize := p.BatchSize
+	if batchSize <= 0 || batchSize > len(results) {
+		batchSize = len(results)
+	}
+	servers := make([]webrtc.ICEServer, batchSize)
+	for i := 0; i < batchSize; i++ {
+		servers[i] = results[i].server
+	}
+	return servers, nil
+}
+
+func (p *STUNPool) probeCached(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool) {
+	key := stunPoolKey(server)
+
+	ttl := p.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultSTUNProbeCacheTTL
+	}
+
+	p.mu.Lock()
+	if p.cache == nil {
+		p.cache = map[string]stunProbeResult{}
+	}
+	if cached, ok := p.cache[key]; ok && time.Since(cached.probedAt) < ttl {
+		p.mu.Unlock()
+		return cached.rtt, cached.reachable
+	}
+	p.mu.Unlock()
+
+	rtt, reachable := p.Probe(ctx, server)
+
+	p.mu.Lock()
+	p.cache[key] = stunProbeResult{rtt: rtt, reachable: reachable, probedAt: time.Now()}
+	p.mu.Unlock()
+
+	return rtt, reachable
+}
+
+func stunPoolKey(server webrtc.ICEServer) string {
+	if len(server.URLs) == 0 {
+		return ""
+	}
+	return server.URLs[0]
+}
+
+// mergeSTUNServers returns existing with any stun:/stuns:-only entries replaced by
+// stunServers, leaving every other entry (e.g. turn:/turns: servers carrying TURN
+// credentials, such as those an ICEServersProvider merges in) untouched. Without this,
+// STUNPool's probed batch would wholesale replace config.ICEServers and silently drop
+// any TURN servers already present.
+func mergeSTUNServers(existing, stunServers []webrtc.ICEServer) []webrtc.ICEServer {
+	merged := make([]webrtc.ICEServer, 0, len(existing)+len(stunServers))
+	for _, server := range existing {
+		if isSTUNOnly(server) {
+			continue
+		}
+		merged = append(merged, server)
+	}
+	merged = append(merged, stunServers...)
+	return merged
+}
+
+// isSTUNOnly reports whether every URL on server is a stun:/stuns: URL.
+func isSTUNOnly(server webrtc.ICEServer) bool {
+	if len(server.URLs) == 0 {
+		return false
+	}
+	for _, url := range server.URLs {
+		if !strings.HasPrefix(url, "stun:") && !strings.HasPrefix(url, "stuns:") {
+			return false
+		}
+	}
+	return true
+}
+
+// DefaultSTUNProbe sends a single STUN Binding Request to server and measures the round
+// trip time. It only supports plain "stun:"/"stuns:" URLs.
+func DefaultSTUNProbe(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool) {
+	if len(server.URLs) == 0 {
+		return 0, false
+	}
+	addr, ok := stunServerAddr(server.URLs[0])
+	if !ok {
+		return 0, false
+	}
+
+	deadline := time.Now().Add(DefaultSTUNProbeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return 0, false
+	}
+	defer conn.Close() //nolint:errcheck
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, false
+	}
+
+	message := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	start := time.Now()
+	if _, err := conn.Write(message.Raw); err != nil {
+		return 0, false
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, false
+	}
+	rtt := time.Since(start)
+
+	response := &stun.Message{Raw: buf[:n]}
+	if err := response.Decode(); err != nil {
+		return 0, false
+	}
+	// Reject responses that don't carry back our own transaction ID: on a shared or
+	// reused UDP socket, a stale or spoofed BindingSuccess from an unrelated exchange
+	// would otherwise be accepted as this probe's result.
+	if response.Type != stun.BindingSuccess || response.TransactionID != message.TransactionID {
+		return 0, false
+	}
+	return rtt, true
+}
+
+// DiscoverReflexiveAddr sends a single STUN Binding Request to stunServerURL and returns
+// the caller's server-reflexive (NAT-mapped) address, as reported in the response's
+// XOR-MAPPED-ADDRESS attribute. Hole-punch dials use this to learn their own externally
+// observed address to register with the signaling server, since no real offer/answer
+// exchange runs to produce it the normal way.
+func DiscoverReflexiveAddr(ctx context.Context, stunServerURL string) (ip string, port int, err error) {
+	addr, ok := stunServerAddr(stunServerURL)
+	if !ok {
+		return "", 0, errors.Errorf("not a stun/stuns url: %q", stunServerURL)
+	}
+
+	deadline := time.Now().Add(DefaultSTUNProbeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return "", 0, err
+	}
+	defer conn.Close() //nolint:errcheck
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", 0, err
+	}
+
+	message := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	if _, err := conn.Write(message.Raw); err != nil {
+		return "", 0, err
+	}
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", 0, err
+	}
+
+	response := &stun.Message{Raw: buf[:n]}
+	if err := response.Decode(); err != nil {
+		return "", 0, err
+	}
+	if response.Type != stun.BindingSuccess || response.TransactionID != message.TransactionID {
+		return "", 0, errors.New("did not get a matching STUN binding success response")
+	}
+
+	var xorAddr stun.XORMappedAddress
+	if err := xorAddr.GetFrom(response); err != nil {
+		return "", 0, err
+	}
+	return xorAddr.IP.String(), xorAddr.Port, nil
+}
+
+// stunServerAddr extracts the host:port portion of a "stun:"/"stuns:" URL, ignoring any
+// trailing "?transport=..." query component.
+func stunServerAddr(rawURL string) (string, bool) {
+	trimmed := strings.TrimPrefix(rawURL, "stuns:")
+	trimmed = strings.TrimPrefix(trimmed, "stun:")
+	if trimmed == rawURL {
+		return "", false
+	}
+	if idx := strings.IndexByte(trimmed, '?'); idx >= 0 {
+		trimmed = trimmed[:idx]
+	}
+	if trimmed == "" {
+		return "", false
+	}
+	return trimmed, true
+}
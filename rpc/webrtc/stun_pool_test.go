@@ -0,0 +1,147 @@
+package rpcwebrtc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func serverFor(url string) webrtc.ICEServer {
+	return webrtc.ICEServer{URLs: []string{url}}
+}
+
+func TestSTUNPoolICEServersSortsAndBatches(t *testing.T) {
+	rtts := map[string]time.Duration{
+		"stun:a": 30 * time.Millisecond,
+		"stun:b": 10 * time.Millisecond,
+		"stun:c": 20 * time.Millisecond,
+		"stun:d": 0, // unreachable
+	}
+	pool := NewSTUNPool([]webrtc.ICEServer{
+		serverFor("stun:a"), serverFor("stun:b"), serverFor("stun:c"), serverFor("stun:d"),
+	}, 2, func(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool) {
+		rtt := rtts[server.URLs[0]]
+		return rtt, server.URLs[0] != "stun:d"
+	})
+
+	servers, err := pool.ICEServers(context.Background())
+	if err != nil {
+		t.Fatalf("ICEServers returned error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected batch of 2, got %d", len(servers))
+	}
+	if servers[0].URLs[0] != "stun:b" || servers[1].URLs[0] != "stun:c" {
+		t.Fatalf("expected fastest two (b, c) in order, got %v", servers)
+	}
+}
+
+func TestSTUNPoolICEServersNoBatchLimitReturnsAllReachable(t *testing.T) {
+	pool := NewSTUNPool([]webrtc.ICEServer{serverFor("stun:a"), serverFor("stun:b")}, 0,
+		func(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool) {
+			return time.Millisecond, true
+		})
+
+	servers, err := pool.ICEServers(context.Background())
+	if err != nil {
+		t.Fatalf("ICEServers returned error: %v", err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("expected all 2 reachable candidates with BatchSize<=0, got %d", len(servers))
+	}
+}
+
+func TestSTUNPoolProbeCachedReusesResultWithinTTL(t *testing.T) {
+	var calls int
+	pool := NewSTUNPool([]webrtc.ICEServer{serverFor("stun:a")}, 1,
+		func(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool) {
+			calls++
+			return 5 * time.Millisecond, true
+		})
+	pool.CacheTTL = time.Minute
+
+	server := serverFor("stun:a")
+	pool.probeCached(context.Background(), server)
+	pool.probeCached(context.Background(), server)
+	pool.probeCached(context.Background(), server)
+
+	if calls != 1 {
+		t.Fatalf("expected probe to run once within TTL, ran %d times", calls)
+	}
+}
+
+func TestSTUNPoolProbeCachedReprobesAfterTTLExpires(t *testing.T) {
+	var calls int
+	pool := NewSTUNPool([]webrtc.ICEServer{serverFor("stun:a")}, 1,
+		func(ctx context.Context, server webrtc.ICEServer) (time.Duration, bool) {
+			calls++
+			return 5 * time.Millisecond, true
+		})
+	pool.CacheTTL = time.Nanosecond
+
+	server := serverFor("stun:a")
+	pool.probeCached(context.Background(), server)
+	time.Sleep(time.Millisecond)
+	pool.probeCached(context.Background(), server)
+
+	if calls != 2 {
+		t.Fatalf("expected probe to re-run after TTL expiry, ran %d times", calls)
+	}
+}
+
+func TestMergeSTUNServersPreservesTURNEntries(t *testing.T) {
+	turn := webrtc.ICEServer{
+		URLs:       []string{"turn:example.com:3478?transport=udp"},
+		Username:   "user",
+		Credential: "pass",
+	}
+	existing := []webrtc.ICEServer{serverFor("stun:old"), turn}
+	probed := []webrtc.ICEServer{serverFor("stun:new")}
+
+	merged := mergeSTUNServers(existing, probed)
+
+	if len(merged) != 2 {
+		t.Fatalf("expected 2 entries (1 TURN + 1 probed STUN), got %d: %v", len(merged), merged)
+	}
+	var haveTURN, haveNewSTUN bool
+	for _, server := range merged {
+		switch server.URLs[0] {
+		case turn.URLs[0]:
+			haveTURN = true
+			if server.Username != "user" || server.Credential != "pass" {
+				t.Fatalf("TURN credentials were not preserved: %+v", server)
+			}
+		case "stun:new":
+			haveNewSTUN = true
+		case "stun:old":
+			t.Fatal("stale STUN-only entry should have been replaced")
+		}
+	}
+	if !haveTURN {
+		t.Fatal("expected TURN entry to survive the merge")
+	}
+	if !haveNewSTUN {
+		t.Fatal("expected probed STUN entry to be present")
+	}
+}
+
+func TestIsSTUNOnly(t *testing.T) {
+	cases := []struct {
+		name   string
+		server webrtc.ICEServer
+		want   bool
+	}{
+		{"stun", serverFor("stun:a"), true},
+		{"stuns", serverFor("stuns:a"), true},
+		{"turn", webrtc.ICEServer{URLs: []string{"turn:a"}}, false},
+		{"mixed", webrtc.ICEServer{URLs: []string{"stun:a", "turn:a"}}, false},
+		{"empty", webrtc.ICEServer{}, false},
+	}
+	for _, c := range cases {
+		if got := isSTUNOnly(c.server); got != c.want {
+			t.Errorf("%s: isSTUNOnly() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/edaniels/golog"
 	"github.com/pion/webrtc/v3"
@@ -17,12 +18,21 @@ import (
 
 	"go.viam.com/utils"
 	webrtcpb "go.viam.com/utils/proto/rpc/webrtc/v1"
+	rpcwebrtc "go.viam.com/utils/rpc/webrtc"
 )
 
 // ErrNoWebRTCSignaler happens if a gRPC request is made on a server that does not support
 // signaling for WebRTC.
 var ErrNoWebRTCSignaler = errors.New("no signaler present")
 
+// ICEServersProvider supplies the ICE servers to use for a WebRTC connection attempt.
+// It is polled once before each dial attempt (and again on every ICE restart), which
+// makes it the right place to plug in TURN servers whose username/credential pairs are
+// short-lived and must be rotated out from under a long-running client.
+type ICEServersProvider interface {
+	ICEServers(ctx context.Context) ([]webrtc.ICEServer, error)
+}
+
 // DialWebRTCOptions control how WebRTC is utilized in a dial attempt.
 type DialWebRTCOptions struct {
 	// Insecure determines if the WebRTC connection is DTLS based.
@@ -38,8 +48,38 @@ type DialWebRTCOptions struct {
 
 	// Config is the WebRTC specific configuration (i.e. ICE settings)
 	Config *webrtc.Configuration
+
+	// ICEServersProvider, when set, is consulted for a fresh list of ICE servers
+	// before each connection attempt and takes precedence over the ICE servers in
+	// Config and any sent back by OptionalWebRTCConfig. Use this to supply TURN
+	// servers with credentials that expire and need to be refreshed periodically.
+	ICEServersProvider ICEServersProvider
+
+	// STUNPool, when set, is probed for the fastest reachable batch of STUN servers
+	// immediately before the peer connection is built, and takes precedence over any
+	// STUN servers in Config.ICEServers or ICEServersProvider's result. Defaults to
+	// rpcwebrtc.DefaultSTUNPool. Hole-punch dials (see HolePunch) do not use it.
+	STUNPool *rpcwebrtc.STUNPool
+
+	// HolePunch, when set, coordinates a direct peer-to-peer connection to a
+	// private peer through the signaling server instead of the usual
+	// offer/answer/candidate exchange. See HolePunchOptions.
+	HolePunch *HolePunchOptions
+
+	// DataChannelOpenTimeout bounds how long to wait for the negotiated data channel to
+	// fire OnOpen once ICE has connected, separate from the overall connection timeout.
+	// Defaults to defaultDataChannelOpenTimeout if zero.
+	DataChannelOpenTimeout time.Duration
+
+	// Observer, when set, receives typed connection lifecycle events and periodic stats
+	// snapshots for the life of the resulting channel.
+	Observer Observer
 }
 
+// defaultDataChannelOpenTimeout is used when DialWebRTCOptions.DataChannelOpenTimeout is
+// unset.
+const defaultDataChannelOpenTimeout = 10 * time.Second
+
 // DialWebRTC connects to the signaling service at the given address and attempts to establish
 // a WebRTC connection with the corresponding peer reflected in the address.
 // It provider client/server functionality for gRPC serviced over
@@ -74,8 +114,14 @@ func dialWebRTC(ctx context.Context, address string, dOpts *dialOptions, logger
 	if err != nil {
 		return nil, err
 	}
+	// connClosed is set once the signaling connection's lifetime has been handed off to
+	// the post-dial watcher below (needed to keep it alive for keepalive-triggered ICE
+	// restarts); until then, any early return here is responsible for closing it.
+	var connClosed bool
 	defer func() {
-		err = multierr.Combine(err, conn.Close())
+		if !connClosed {
+			err = multierr.Combine(err, conn.Close())
+		}
 	}()
 
 	logger.Debug("connected")
@@ -94,7 +140,33 @@ func dialWebRTC(ctx context.Context, address string, dOpts *dialOptions, logger
 		config = *dOptsCopy.webrtcOpts.Config
 	}
 	extendedConfig := extendWebRTCConfig(&config, configResp.Config)
-	pc, dc, err := newPeerConnectionForClient(ctx, extendedConfig, dOptsCopy.webrtcOpts.DisableTrickleICE, logger)
+	if provider := dOptsCopy.webrtcOpts.ICEServersProvider; provider != nil {
+		iceServers, err := provider.ICEServers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(iceServers) > 0 {
+			extendedConfig.ICEServers = iceServers
+		}
+	}
+	if hp := dOptsCopy.webrtcOpts.HolePunch; hp != nil {
+		holeCh, holeErr := dialWebRTCHolePunch(
+			ctx, signalCtx, conn, signalingClient, hp, extendedConfig,
+			dOptsCopy.webrtcOpts.Observer, dOptsCopy.webrtcOpts.DataChannelOpenTimeout, logger,
+		)
+		if holeErr == nil {
+			// dialWebRTCHolePunch takes over closing conn, once clientCh itself closes,
+			// same as the non-hole-punch path below.
+			connClosed = true
+		}
+		return holeCh, holeErr
+	}
+
+	stunPool := dOptsCopy.webrtcOpts.STUNPool
+	if stunPool == nil {
+		stunPool = rpcwebrtc.DefaultSTUNPool
+	}
+	pc, dc, err := rpcwebrtc.NewPeerConnectionForClient(ctx, extendedConfig, stunPool, dOptsCopy.webrtcOpts.DisableTrickleICE, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +178,15 @@ func dialWebRTC(ctx context.Context, address string, dOpts *dialOptions, logger
 	}()
 
 	exchangeCtx, exchangeCancel := context.WithCancel(signalCtx)
-	defer exchangeCancel()
+	// exchangeCancelled mirrors connClosed: once the channel is up, cancellation of the
+	// signaling exchange is deferred to clientCh.Closed() so CallUpdate keeps working for
+	// keepalive-triggered ICE restarts for the life of the channel.
+	var exchangeCancelled bool
+	defer func() {
+		if !exchangeCancelled {
+			exchangeCancel()
+		}
+	}()
 
 	errCh := make(chan error)
 	sendErr := func(err error) {
@@ -184,7 +264,7 @@ func dialWebRTC(ctx context.Context, address string, dOpts *dialOptions, logger
 	}
 
 	// TODO(https://github.com/viamrobotics/goutils/issues/12): do separate auth here
-	clientCh := newWebRTCClientChannel(pc, dc, logger)
+	clientCh := newWebRTCClientChannel(pc, dc, dOptsCopy.webrtcOpts.Observer, logger)
 
 	exchangeCandidates := func() error {
 		haveInit := false
@@ -231,6 +311,17 @@ func dialWebRTC(ctx context.Context, address string, dOpts *dialOptions, logger
 				if callResp.Uuid != uuid {
 					return errors.Errorf("uuid mismatch; have=%q want=%q", callResp.Uuid, uuid)
 				}
+				if s.Update.Sdp != "" {
+					// the answer to an ICE-restart offer sent via performICERestart
+					answer := webrtc.SessionDescription{}
+					if err := decodeSDP(s.Update.Sdp, &answer); err != nil {
+						return err
+					}
+					if err := pc.SetRemoteDescription(answer); err != nil {
+						return err
+					}
+					continue
+				}
 				cand := iceCandidateFromProto(s.Update.Candidate)
 				if err := pc.AddICECandidate(cand); err != nil {
 					return err
@@ -272,9 +363,98 @@ func dialWebRTC(ctx context.Context, address string, dOpts *dialOptions, logger
 		})
 		return nil, multierr.Combine(callErr, err)
 	}
+
+	openTimeout := dOptsCopy.webrtcOpts.DataChannelOpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = defaultDataChannelOpenTimeout
+	}
+	openCtx, openCancel := context.WithTimeout(ctx, openTimeout)
+	openErr := clientCh.WhenOpen(openCtx)
+	openCancel()
+	if openErr != nil {
+		return nil, multierr.Combine(openErr, clientCh.Close())
+	}
+
 	if err := sendDone(); err != nil {
 		return nil, err
 	}
+
+	// Hand the signaling connection and exchange context off to the life of the channel
+	// so a keepalive-triggered ICE restart can keep re-using them.
+	connClosed = true
+	exchangeCancelled = true
+	utils.PanicCapturingGoWithCallback(func() {
+		<-clientCh.Closed()
+		exchangeCancel()
+		utils.UncheckedError(conn.Close())
+	}, func(err interface{}) {
+		logger.Errorw("panic while closing signaling connection", "error", err)
+	})
+
+	utils.PanicCapturingGoWithCallback(func() {
+		for {
+			select {
+			case <-clientCh.Closed():
+				return
+			case <-exchangeCtx.Done():
+				return
+			case <-clientCh.ICERestartRequested():
+				if err := performICERestart(exchangeCtx, pc, signalingClient, uuid, dOptsCopy.webrtcOpts.ICEServersProvider); err != nil {
+					logger.Warnw("error restarting ICE", "error", err)
+				}
+			}
+		}
+	}, func(err interface{}) {
+		logger.Errorw("panic while handling ICE restart", "error", err)
+	})
+
 	successful = true
 	return clientCh, nil
-}
\ No newline at end of file
+}
+
+// performICERestart issues a fresh ICE-restart offer over the existing signaling
+// exchange; the corresponding answer arrives back through exchangeCandidates as a
+// CallResponse_Update carrying an Sdp rather than a Candidate. If provider is non-nil, it
+// is re-polled and applied to pc before the restart offer is created, so short-lived TURN
+// credentials baked into the original config get refreshed rather than silently expiring
+// on a connection that outlives them.
+func performICERestart(
+	ctx context.Context,
+	pc *webrtc.PeerConnection,
+	signalingClient webrtcpb.SignalingServiceClient,
+	uuid string,
+	provider ICEServersProvider,
+) error {
+	if provider != nil {
+		iceServers, err := provider.ICEServers(ctx)
+		if err != nil {
+			return err
+		}
+		if len(iceServers) > 0 {
+			config := pc.GetConfiguration()
+			config.ICEServers = iceServers
+			if err := pc.SetConfiguration(config); err != nil {
+				return err
+			}
+		}
+	}
+
+	offer, err := pc.CreateOffer(&webrtc.OfferOptions{ICERestart: true})
+	if err != nil {
+		return err
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	encodedSDP, err := encodeSDP(pc.LocalDescription())
+	if err != nil {
+		return err
+	}
+	_, err = signalingClient.CallUpdate(ctx, &webrtcpb.CallUpdateRequest{
+		Uuid: uuid,
+		Update: &webrtcpb.CallUpdateRequest_Sdp{
+			Sdp: encodedSDP,
+		},
+	})
+	return err
+}
@@ -0,0 +1,395 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/webrtc/v3"
+	"github.com/pkg/errors"
+
+	"go.viam.com/utils"
+)
+
+// keepAliveInterval is how often an application-level ping is sent on the negotiated
+// data channel to detect a silently broken ICE pair.
+const keepAliveInterval = 3 * time.Second
+
+// statsPollInterval is how often a WebRTCStats snapshot is gathered and, if an Observer
+// is configured, delivered as a WebRTCEventStats event.
+const statsPollInterval = 10 * time.Second
+
+// maxMissedKeepAlives is how many consecutive un-acked pings are tolerated before an
+// ICE restart is requested.
+const maxMissedKeepAlives = 3
+
+// keepAlivePingSize is the size, in bytes, of the random nonce sent with each ping.
+const keepAlivePingSize = 64
+
+// controlOpcode is a one-byte prefix on every message sent over the negotiated data
+// channel, distinguishing keepalive control frames from ordinary gRPC-over-WebRTC
+// payload frames so both can share the single dc.OnMessage callback pion allows.
+type controlOpcode byte
+
+const (
+	controlOpcodePing controlOpcode = iota + 1
+	controlOpcodePong
+	// controlOpcodeData prefixes ordinary gRPC-over-WebRTC traffic; see Send and OnData.
+	controlOpcodeData
+)
+
+// ConnectionState describes the lifecycle of a webrtcClientChannel as observed through
+// its underlying ICE connection and application-level keepalive.
+type ConnectionState int
+
+const (
+	// ConnectionStateConnecting is set before the channel has become ready for the first time.
+	ConnectionStateConnecting ConnectionState = iota
+	// ConnectionStateConnected is set each time the ICE connection state reaches Connected.
+	// Answered keepalive pings do not set it.
+	ConnectionStateConnected
+	// ConnectionStateReconnecting is set when keepalives have started failing or ICE has
+	// dropped to Disconnected/Failed, and an ICE restart has been requested.
+	ConnectionStateReconnecting
+	// ConnectionStateClosed is set once the channel has been closed.
+	ConnectionStateClosed
+)
+
+// webrtcClientChannel manages the client side of a negotiated WebRTC data channel used
+// to carry gRPC traffic, including an application-level keepalive that detects silent
+// ICE failures and requests a restart rather than tearing the whole channel down.
+type webrtcClientChannel struct {
+	pc       *webrtc.PeerConnection
+	dc       *webrtc.DataChannel
+	logger   golog.Logger
+	observer Observer
+
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	opened     chan struct{}
+	openedOnce sync.Once
+
+	closed     chan struct{}
+	closedOnce sync.Once
+
+	connStateCh chan ConnectionState
+
+	pingMu          sync.Mutex
+	pingNonce       [keepAlivePingSize]byte
+	pingSentAt      time.Time
+	pingOutstanding bool
+	missedPings     int
+
+	rttMu sync.Mutex
+	rtt   time.Duration
+
+	dataMu      sync.Mutex
+	dataHandler func(webrtc.DataChannelMessage)
+
+	restartRequested chan struct{}
+
+	activeBackgroundWorkers sync.WaitGroup
+}
+
+func newWebRTCClientChannel(pc *webrtc.PeerConnection, dc *webrtc.DataChannel, observer Observer, logger golog.Logger) *webrtcClientChannel {
+	ch := &webrtcClientChannel{
+		pc:               pc,
+		dc:               dc,
+		logger:           logger,
+		observer:         observer,
+		ready:            make(chan struct{}),
+		opened:           make(chan struct{}),
+		closed:           make(chan struct{}),
+		connStateCh:      make(chan ConnectionState, 1),
+		restartRequested: make(chan struct{}, 1),
+	}
+	ch.setConnectionState(ConnectionStateConnecting)
+
+	pc.OnICEConnectionStateChange(ch.onICEConnectionStateChange)
+	pc.OnConnectionStateChange(ch.onPeerConnectionStateChange)
+	pc.OnSignalingStateChange(ch.onSignalingStateChange)
+	if iceTransport := pc.SCTP().Transport().ICETransport(); iceTransport != nil {
+		iceTransport.OnSelectedCandidatePairChange(ch.onSelectedCandidatePairChange)
+	}
+	dc.OnOpen(func() { ch.openedOnce.Do(func() { close(ch.opened) }) })
+	dc.OnMessage(ch.onMessage)
+
+	ch.activeBackgroundWorkers.Add(1)
+	go ch.keepAliveLoop()
+
+	if ch.observer != nil {
+		ch.activeBackgroundWorkers.Add(1)
+		go ch.statsLoop()
+	}
+
+	return ch
+}
+
+func (ch *webrtcClientChannel) onICEConnectionStateChange(connectionState webrtc.ICEConnectionState) {
+	ch.notify(WebRTCEvent{Type: WebRTCEventICEConnectionStateChange, ICEConnectionState: connectionState})
+	switch connectionState {
+	case webrtc.ICEConnectionStateConnected:
+		ch.readyOnce.Do(func() { close(ch.ready) })
+		ch.setConnectionState(ConnectionStateConnected)
+	case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed:
+		ch.setConnectionState(ConnectionStateReconnecting)
+		ch.requestICERestart()
+	}
+}
+
+func (ch *webrtcClientChannel) onPeerConnectionStateChange(connectionState webrtc.PeerConnectionState) {
+	ch.notify(WebRTCEvent{Type: WebRTCEventPeerConnectionStateChange, PeerConnectionState: connectionState})
+}
+
+func (ch *webrtcClientChannel) onSignalingStateChange(signalingState webrtc.SignalingState) {
+	ch.notify(WebRTCEvent{Type: WebRTCEventSignalingStateChange, SignalingState: signalingState})
+}
+
+func (ch *webrtcClientChannel) onSelectedCandidatePairChange(pair *webrtc.ICECandidatePair) {
+	ch.notify(WebRTCEvent{Type: WebRTCEventSelectedCandidatePairChange, SelectedCandidatePair: pair})
+}
+
+func (ch *webrtcClientChannel) notify(event WebRTCEvent) {
+	if ch.observer == nil {
+		return
+	}
+	ch.observer.ObserveWebRTCEvent(event)
+}
+
+func (ch *webrtcClientChannel) statsLoop() {
+	defer ch.activeBackgroundWorkers.Done()
+	ticker := time.NewTicker(statsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ch.closed:
+			return
+		case <-ticker.C:
+		}
+		stats := statsFromReport(ch.pc.GetStats())
+		ch.notify(WebRTCEvent{Type: WebRTCEventStats, Stats: &stats})
+	}
+}
+
+// Stats returns a stable, pion-independent snapshot of the underlying peer
+// connection's current stats, so operators can wire it into Prometheus without
+// depending on pion types directly.
+func (ch *webrtcClientChannel) Stats() WebRTCStats {
+	return statsFromReport(ch.pc.GetStats())
+}
+
+// setConnectionState keeps connStateCh holding only the most recent state so that a
+// slow or absent reader never blocks the channel's internal state machine.
+func (ch *webrtcClientChannel) setConnectionState(state ConnectionState) {
+	select {
+	case <-ch.connStateCh:
+	default:
+	}
+	select {
+	case ch.connStateCh <- state:
+	default:
+	}
+}
+
+// ConnectionState returns a channel carrying the most recently observed connection
+// state. Callers (e.g. gRPC users of DialWebRTC) can select on this to observe
+// transient outages instead of losing in-flight calls the moment ICE hiccups.
+func (ch *webrtcClientChannel) ConnectionState() <-chan ConnectionState {
+	return ch.connStateCh
+}
+
+// RTT returns the most recently measured application-level keepalive round trip time.
+// It is zero until the first keepalive ping has been acknowledged.
+func (ch *webrtcClientChannel) RTT() time.Duration {
+	ch.rttMu.Lock()
+	defer ch.rttMu.Unlock()
+	return ch.rtt
+}
+
+func (ch *webrtcClientChannel) requestICERestart() {
+	select {
+	case ch.restartRequested <- struct{}{}:
+	default:
+	}
+}
+
+// ICERestartRequested fires when n consecutive keepalive pings have gone unanswered, or
+// the ICE connection state dropped to Disconnected/Failed. The owner of the signaling
+// exchange (dialWebRTC) listens on this to send a fresh ICE-restart offer through
+// signalingClient.CallUpdate (see performICERestart).
+func (ch *webrtcClientChannel) ICERestartRequested() <-chan struct{} {
+	return ch.restartRequested
+}
+
+func (ch *webrtcClientChannel) keepAliveLoop() {
+	defer ch.activeBackgroundWorkers.Done()
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ch.closed:
+			return
+		case <-ticker.C:
+		}
+		if ch.dc.ReadyState() != webrtc.DataChannelStateOpen {
+			continue
+		}
+		if ch.recordOutstandingPing() {
+			ch.logger.Warnw("missed too many keepalive pings; requesting ICE restart", "missed", maxMissedKeepAlives)
+			ch.setConnectionState(ConnectionStateReconnecting)
+			ch.requestICERestart()
+		}
+		if err := ch.sendPing(); err != nil {
+			ch.logger.Debugw("error sending keepalive ping", "error", err)
+		}
+	}
+}
+
+// recordOutstandingPing accounts for the previous ping, if any, and reports whether the
+// miss streak has crossed maxMissedKeepAlives.
+func (ch *webrtcClientChannel) recordOutstandingPing() bool {
+	ch.pingMu.Lock()
+	defer ch.pingMu.Unlock()
+	if !ch.pingOutstanding {
+		return false
+	}
+	ch.missedPings++
+	if ch.missedPings >= maxMissedKeepAlives {
+		ch.missedPings = 0
+		return true
+	}
+	return false
+}
+
+func (ch *webrtcClientChannel) sendPing() error {
+	var nonce [keepAlivePingSize]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+
+	ch.pingMu.Lock()
+	ch.pingNonce = nonce
+	ch.pingSentAt = time.Now()
+	ch.pingOutstanding = true
+	ch.pingMu.Unlock()
+
+	frame := make([]byte, 0, keepAlivePingSize+1)
+	frame = append(frame, byte(controlOpcodePing))
+	frame = append(frame, nonce[:]...)
+	return ch.dc.Send(frame)
+}
+
+func (ch *webrtcClientChannel) onMessage(msg webrtc.DataChannelMessage) {
+	if len(msg.Data) == 0 {
+		return
+	}
+	switch controlOpcode(msg.Data[0]) {
+	case controlOpcodePong:
+		ch.handlePong(msg.Data[1:])
+	case controlOpcodePing:
+		// echo back as a pong so the remote side can measure RTT too
+		frame := append([]byte{byte(controlOpcodePong)}, msg.Data[1:]...)
+		utils.UncheckedError(ch.dc.Send(frame))
+	case controlOpcodeData:
+		ch.dataMu.Lock()
+		handler := ch.dataHandler
+		ch.dataMu.Unlock()
+		if handler == nil {
+			ch.logger.Warn("dropping gRPC-over-WebRTC data channel message with no OnData handler registered")
+			return
+		}
+		handler(webrtc.DataChannelMessage{IsString: msg.IsString, Data: msg.Data[1:]})
+	default:
+		ch.logger.Warnw("dropping data channel message with unrecognized control opcode", "opcode", msg.Data[0])
+	}
+}
+
+// OnData registers handler to be invoked, on the data channel's own goroutine, for every
+// message sent through Send by the remote peer (i.e. ordinary gRPC-over-WebRTC traffic,
+// as opposed to this channel's own keepalive control frames). Only one handler may be
+// registered at a time; a later call replaces an earlier one.
+func (ch *webrtcClientChannel) OnData(handler func(msg webrtc.DataChannelMessage)) {
+	ch.dataMu.Lock()
+	defer ch.dataMu.Unlock()
+	ch.dataHandler = handler
+}
+
+// Send writes data to the remote peer as ordinary gRPC-over-WebRTC traffic. Callers must
+// use this, rather than writing to the underlying *webrtc.DataChannel directly, so this
+// channel's application-level keepalive pings/pongs can keep sharing the single
+// dc.OnMessage callback pion allows without being misread as gRPC payload or vice versa.
+func (ch *webrtcClientChannel) Send(data []byte) error {
+	frame := make([]byte, 0, len(data)+1)
+	frame = append(frame, byte(controlOpcodeData))
+	frame = append(frame, data...)
+	return ch.dc.Send(frame)
+}
+
+func (ch *webrtcClientChannel) handlePong(nonce []byte) {
+	if len(nonce) != keepAlivePingSize {
+		return
+	}
+
+	ch.pingMu.Lock()
+	defer ch.pingMu.Unlock()
+	if !ch.pingOutstanding || !equalNonce(ch.pingNonce, nonce) {
+		return
+	}
+	sentAt := ch.pingSentAt
+	ch.pingOutstanding = false
+	ch.missedPings = 0
+
+	ch.rttMu.Lock()
+	ch.rtt = time.Since(sentAt)
+	ch.rttMu.Unlock()
+}
+
+func equalNonce(have [keepAlivePingSize]byte, want []byte) bool {
+	for i, b := range want {
+		if have[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// Ready returns a channel that is closed once the underlying ICE connection first
+// reaches the Connected state.
+func (ch *webrtcClientChannel) Ready() <-chan struct{} {
+	return ch.ready
+}
+
+// WhenOpen blocks until the negotiated data channel's OnOpen has fired, ctx is done, or
+// the channel is closed, whichever comes first. Waiting for this rather than only
+// Ready() (which can fire before the SCTP association backing the data channel has
+// actually finished opening) avoids racing the first gRPC call against DTLS/SCTP setup.
+func (ch *webrtcClientChannel) WhenOpen(ctx context.Context) error {
+	select {
+	case <-ch.opened:
+		return nil
+	case <-ch.closed:
+		return errors.New("webrtc channel closed before data channel opened")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Closed returns a channel that is closed once the channel has been closed.
+func (ch *webrtcClientChannel) Closed() <-chan struct{} {
+	return ch.closed
+}
+
+// Close closes the underlying peer connection and stops the keepalive loop.
+func (ch *webrtcClientChannel) Close() error {
+	var err error
+	ch.closedOnce.Do(func() {
+		ch.setConnectionState(ConnectionStateClosed)
+		close(ch.closed)
+		err = ch.pc.Close()
+	})
+	ch.activeBackgroundWorkers.Wait()
+	return err
+}
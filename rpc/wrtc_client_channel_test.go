@@ -0,0 +1,45 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestWebRTCClientChannelOnDataDemux(t *testing.T) {
+	ch := &webrtcClientChannel{logger: golog.NewTestLogger(t)}
+
+	var got []byte
+	received := make(chan struct{})
+	ch.OnData(func(msg webrtc.DataChannelMessage) {
+		got = msg.Data
+		close(received)
+	})
+
+	frame := append([]byte{byte(controlOpcodeData)}, []byte("hello grpc")...)
+	ch.onMessage(webrtc.DataChannelMessage{Data: frame})
+
+	select {
+	case <-received:
+	default:
+		t.Fatal("OnData handler was not invoked for a data-opcode frame")
+	}
+	if string(got) != "hello grpc" {
+		t.Fatalf("expected stripped payload %q, got %q", "hello grpc", got)
+	}
+}
+
+func TestWebRTCClientChannelOnDataIgnoresControlFrames(t *testing.T) {
+	ch := &webrtcClientChannel{logger: golog.NewTestLogger(t)}
+
+	var called bool
+	ch.OnData(func(msg webrtc.DataChannelMessage) { called = true })
+
+	pong := append([]byte{byte(controlOpcodePong)}, make([]byte, keepAlivePingSize)...)
+	ch.onMessage(webrtc.DataChannelMessage{Data: pong})
+
+	if called {
+		t.Fatal("OnData handler was invoked for a keepalive control frame")
+	}
+}
@@ -0,0 +1,191 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/edaniels/golog"
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/multierr"
+
+	"go.viam.com/utils"
+	webrtcpb "go.viam.com/utils/proto/rpc/webrtc/v1"
+	rpcwebrtc "go.viam.com/utils/rpc/webrtc"
+)
+
+// HolePunchOptions enables the hole-punch dial path: rather than one side sending an
+// offer for the other to answer, the signaling server coordinates a synchronized start
+// time so both sides open their PeerConnection and fire their first STUN Binding
+// Requests at roughly the same instant, opening a pinhole through NATs on both ends.
+type HolePunchOptions struct {
+	// PeerID identifies the remote peer to connect to, as previously registered with
+	// the signaling server via RegisterPeer.
+	PeerID string
+}
+
+// dialWebRTCHolePunch implements the HolePunch path of dialWebRTC: it registers this
+// peer's ICE credentials, asks the signaling server to coordinate a simultaneous
+// connect with opts.PeerID, and then builds a PeerConnection timed to that start. On
+// success it takes over responsibility for closing conn (the signaling gRPC connection),
+// mirroring the non-hole-punch path in dialWebRTC.
+func dialWebRTCHolePunch(
+	ctx context.Context,
+	signalCtx context.Context,
+	conn ClientConn,
+	signalingClient webrtcpb.SignalingServiceClient,
+	opts *HolePunchOptions,
+	config webrtc.Configuration,
+	observer Observer,
+	dataChannelOpenTimeout time.Duration,
+	logger golog.Logger,
+) (ch *webrtcClientChannel, err error) {
+	localUFrag, localPwd, err := generateICECredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	// The certificate is generated before RegisterPeer so its fingerprint can be
+	// advertised to the remote peer in time for NewPeerConnectionForHolePunch to build a
+	// remote description describing the real certificate the remote peer will present,
+	// rather than our own.
+	localCert, localFingerprintAlgorithm, localFingerprintValue, err := rpcwebrtc.GenerateHolePunchCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Likewise, our own server-reflexive address must be known and advertised before
+	// RegisterPeer: without it, the remote peer has no address to send its first Binding
+	// Requests to, and the synchronized start time alone can't open a pinhole.
+	reflexiveIP, reflexivePort, err := rpcwebrtc.DiscoverReflexiveAddr(signalCtx, stunServerURL(config))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := signalingClient.RegisterPeer(signalCtx, &webrtcpb.RegisterPeerRequest{
+		UfragFragment:        localUFrag,
+		Password:             localPwd,
+		FingerprintAlgorithm: localFingerprintAlgorithm,
+		FingerprintValue:     localFingerprintValue,
+		ReflexiveIp:          reflexiveIP,
+		ReflexivePort:        int32(reflexivePort),
+	}); err != nil {
+		return nil, err
+	}
+
+	connectResp, err := signalingClient.HolePunchConnect(signalCtx, &webrtcpb.HolePunchConnectRequest{
+		PeerId: opts.PeerID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	startAt := time.UnixMicro(connectResp.StartAtUnixMicros)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(time.Until(startAt)):
+	}
+
+	pc, dc, err := rpcwebrtc.NewPeerConnectionForHolePunch(ctx, config, rpcwebrtc.HolePunchConfig{
+		LocalUFrag:                 localUFrag,
+		LocalPwd:                   localPwd,
+		RemoteUFrag:                connectResp.RemoteUfragFragment,
+		RemotePwd:                  connectResp.RemotePassword,
+		LocalCert:                  localCert,
+		RemoteFingerprintAlgorithm: connectResp.RemoteFingerprintAlgorithm,
+		RemoteFingerprintValue:     connectResp.RemoteFingerprintValue,
+		RemoteReflexiveIP:          connectResp.RemoteReflexiveIp,
+		RemoteReflexivePort:        int(connectResp.RemoteReflexivePort),
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+	var successful bool
+	defer func() {
+		if !successful {
+			err = multierr.Combine(err, pc.Close())
+		}
+	}()
+
+	clientCh := newWebRTCClientChannel(pc, dc, observer, logger)
+
+	// Mirror the WhenOpen gate used by the normal dial path (see dialWebRTC) so a
+	// hole-punched connection can't be returned to the caller before the data channel's
+	// OnOpen has actually fired, racing the first gRPC call against DTLS/SCTP setup.
+	openTimeout := dataChannelOpenTimeout
+	if openTimeout <= 0 {
+		openTimeout = defaultDataChannelOpenTimeout
+	}
+	openCtx, openCancel := context.WithTimeout(ctx, openTimeout)
+	openErr := clientCh.WhenOpen(openCtx)
+	openCancel()
+	if openErr != nil {
+		return nil, multierr.Combine(openErr, clientCh.Close())
+	}
+
+	// Hand conn off to the life of the channel, same as the non-hole-punch path, so it
+	// stays open instead of being closed by dialWebRTC's defer the moment this function
+	// returns.
+	utils.PanicCapturingGoWithCallback(func() {
+		<-clientCh.Closed()
+		utils.UncheckedError(conn.Close())
+	}, func(err interface{}) {
+		logger.Errorw("panic while closing signaling connection", "error", err)
+	})
+
+	// Unlike the non-hole-punch path, a hole-punched PeerConnection's ICE credentials and
+	// DTLS certificate are pinned at construction (SettingEngine.SetICECredentials,
+	// config.Certificates), so it cannot be renegotiated in place the way performICERestart
+	// renegotiates a normal connection. Rather than silently ignoring ICERestartRequested
+	// (which would otherwise leave the channel stuck reporting ConnectionStateReconnecting
+	// forever with no signaling connection alive to service a real recovery anyway), close
+	// the channel so the failure surfaces immediately and the caller can redial.
+	utils.PanicCapturingGoWithCallback(func() {
+		select {
+		case <-clientCh.Closed():
+		case <-clientCh.ICERestartRequested():
+			logger.Warn("hole-punched connection cannot be ICE-restarted in place; closing so the caller can redial")
+			utils.UncheckedError(clientCh.Close())
+		}
+	}, func(err interface{}) {
+		logger.Errorw("panic while handling hole-punch ICE restart request", "error", err)
+	})
+
+	successful = true
+	return clientCh, nil
+}
+
+// stunServerFallbackURL is used by stunServerURL when config carries no STUN server of
+// its own to probe for our reflexive address.
+const stunServerFallbackURL = "stun:global.stun.twilio.com:3478?transport=udp"
+
+// stunServerURL picks a stun:/stuns: URL to use for DiscoverReflexiveAddr, preferring the
+// first STUN server already present in config and falling back to the same default
+// Twilio STUN server used elsewhere in this package.
+func stunServerURL(config webrtc.Configuration) string {
+	for _, server := range config.ICEServers {
+		for _, url := range server.URLs {
+			if strings.HasPrefix(url, "stun:") || strings.HasPrefix(url, "stuns:") {
+				return url
+			}
+		}
+	}
+	return stunServerFallbackURL
+}
+
+// generateICECredentials returns a random ufrag/password pair suitable for
+// SettingEngine.SetICECredentials, sized per the ICE spec (4-256 / 22-256 chars).
+func generateICECredentials() (ufrag, pwd string, err error) {
+	ufragBytes := make([]byte, 6)
+	if _, err := rand.Read(ufragBytes); err != nil {
+		return "", "", err
+	}
+	pwdBytes := make([]byte, 24)
+	if _, err := rand.Read(pwdBytes); err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(ufragBytes), base64.RawURLEncoding.EncodeToString(pwdBytes), nil
+}
@@ -0,0 +1,30 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestStunServerURLPrefersConfiguredSTUNServer(t *testing.T) {
+	config := webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{
+			{URLs: []string{"turn:example.com:3478"}, Username: "u", Credential: "p"},
+			{URLs: []string{"stun:stun.example.com:3478"}},
+		},
+	}
+	if got := stunServerURL(config); got != "stun:stun.example.com:3478" {
+		t.Fatalf("stunServerURL() = %q, want the configured STUN server", got)
+	}
+}
+
+func TestStunServerURLFallsBackWithNoSTUNServer(t *testing.T) {
+	config := webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{
+			{URLs: []string{"turn:example.com:3478"}, Username: "u", Credential: "p"},
+		},
+	}
+	if got := stunServerURL(config); got != stunServerFallbackURL {
+		t.Fatalf("stunServerURL() = %q, want fallback %q", got, stunServerFallbackURL)
+	}
+}
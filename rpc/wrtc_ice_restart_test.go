@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+
+	webrtcpb "go.viam.com/utils/proto/rpc/webrtc/v1"
+)
+
+// fakeICEServersProvider is a minimal ICEServersProvider for exercising performICERestart's
+// re-poll behavior without a real TURN deployment.
+type fakeICEServersProvider struct {
+	iceServers []webrtc.ICEServer
+	err        error
+}
+
+func (f *fakeICEServersProvider) ICEServers(ctx context.Context) ([]webrtc.ICEServer, error) {
+	return f.iceServers, f.err
+}
+
+// fakeSignalingClient lets a test override just CallUpdate, which is all performICERestart
+// calls on the client.
+type fakeSignalingClient struct {
+	webrtcpb.SignalingServiceClient
+	callUpdate func(ctx context.Context, in *webrtcpb.CallUpdateRequest, opts ...grpc.CallOption) (*webrtcpb.CallUpdateResponse, error)
+}
+
+func (f *fakeSignalingClient) CallUpdate(
+	ctx context.Context,
+	in *webrtcpb.CallUpdateRequest,
+	opts ...grpc.CallOption,
+) (*webrtcpb.CallUpdateResponse, error) {
+	return f.callUpdate(ctx, in, opts...)
+}
+
+func TestPerformICERestartPropagatesProviderError(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection() error = %v", err)
+	}
+	defer pc.Close() //nolint:errcheck
+
+	provider := &fakeICEServersProvider{err: errors.New("turn credentials unavailable")}
+
+	// signalingClient is never called: a provider error must short-circuit before any
+	// offer is created or sent, so nil here also asserts that.
+	err = performICERestart(context.Background(), pc, nil, "uuid", provider)
+	if err == nil {
+		t.Fatal("performICERestart() expected provider error, got nil")
+	}
+}
+
+func TestPerformICERestartAppliesRefreshedProviderICEServers(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{
+		ICEServers: []webrtc.ICEServer{{URLs: []string{"stun:stun.example.com:3478"}}},
+	})
+	if err != nil {
+		t.Fatalf("NewPeerConnection() error = %v", err)
+	}
+	defer pc.Close() //nolint:errcheck
+
+	refreshed := []webrtc.ICEServer{{URLs: []string{"turn:turn.example.com:3478"}, Username: "u", Credential: "p"}}
+	provider := &fakeICEServersProvider{iceServers: refreshed}
+
+	var sawUpdate bool
+	client := &fakeSignalingClient{
+		callUpdate: func(ctx context.Context, in *webrtcpb.CallUpdateRequest, opts ...grpc.CallOption) (*webrtcpb.CallUpdateResponse, error) {
+			sawUpdate = true
+			return &webrtcpb.CallUpdateResponse{}, nil
+		},
+	}
+
+	if err := performICERestart(context.Background(), pc, client, "uuid", provider); err != nil {
+		t.Fatalf("performICERestart() error = %v", err)
+	}
+	if !sawUpdate {
+		t.Fatal("performICERestart() never sent the ICE-restart offer over signaling")
+	}
+
+	got := pc.GetConfiguration().ICEServers
+	if len(got) != 1 || len(got[0].URLs) != 1 || got[0].URLs[0] != "turn:turn.example.com:3478" {
+		t.Fatalf("performICERestart() left pc configured with %+v, want the provider's refreshed servers", got)
+	}
+}
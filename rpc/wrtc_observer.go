@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// WebRTCEventType enumerates the kinds of connection lifecycle events an Observer is
+// notified of.
+type WebRTCEventType int
+
+const (
+	// WebRTCEventICEConnectionStateChange fires on every ICE connection state transition.
+	WebRTCEventICEConnectionStateChange WebRTCEventType = iota
+	// WebRTCEventPeerConnectionStateChange fires on every overall peer connection state transition.
+	WebRTCEventPeerConnectionStateChange
+	// WebRTCEventSignalingStateChange fires on every signaling state transition.
+	WebRTCEventSignalingStateChange
+	// WebRTCEventSelectedCandidatePairChange fires whenever ICE selects a new candidate pair.
+	WebRTCEventSelectedCandidatePairChange
+	// WebRTCEventStats fires periodically with a WebRTCStats snapshot; see Stats.
+	WebRTCEventStats
+)
+
+// WebRTCEvent is a single observability event delivered to an Observer. Only the
+// field(s) corresponding to Type are populated.
+type WebRTCEvent struct {
+	Type WebRTCEventType
+
+	ICEConnectionState    webrtc.ICEConnectionState
+	PeerConnectionState   webrtc.PeerConnectionState
+	SignalingState        webrtc.SignalingState
+	SelectedCandidatePair *webrtc.ICECandidatePair
+	Stats                 *WebRTCStats
+}
+
+// Observer receives typed lifecycle events and periodic stats snapshots for a
+// DialWebRTC connection. Implementations should return quickly; ObserveWebRTCEvent is
+// called synchronously from the connection's own event and stats-polling goroutines.
+type Observer interface {
+	ObserveWebRTCEvent(event WebRTCEvent)
+}
+
+// WebRTCDataChannelStats summarizes a single data channel's throughput.
+type WebRTCDataChannelStats struct {
+	Label         string
+	BytesSent     uint64
+	BytesReceived uint64
+}
+
+// WebRTCStats is a stable, pion-independent snapshot of a peer connection's stats,
+// suitable for exporting to Prometheus or similar without depending on pion types
+// directly. It holds the nominated candidate pair's RTT and loss estimate and each data
+// channel's byte counts; the other entries of the underlying stats report are not kept.
+type WebRTCStats struct {
+	RTT          time.Duration
+	PacketsLost  int64
+	DataChannels []WebRTCDataChannelStats
+}
+
+// statsFromReport distills a full webrtc.StatsReport down to the stable WebRTCStats
+// shape used by Observer and webrtcClientChannel.Stats.
+func statsFromReport(report webrtc.StatsReport) WebRTCStats {
+	var stats WebRTCStats
+	for _, stat := range report {
+		switch s := stat.(type) {
+		case webrtc.DataChannelStats:
+			stats.DataChannels = append(stats.DataChannels, WebRTCDataChannelStats{
+				Label:         s.Label,
+				BytesSent:     s.BytesSent,
+				BytesReceived: s.BytesReceived,
+			})
+		case webrtc.ICECandidatePairStats:
+			// Nominated identifies the single currently-selected pair; anything else,
+			// including other Succeeded pairs left over from earlier checks or an ICE
+			// restart, must not be folded into the reported RTT/loss.
+			if !s.Nominated {
+				continue
+			}
+			stats.RTT = time.Duration(s.CurrentRoundTripTime * float64(time.Second))
+			// best-effort proxy for loss on the selected pair: connectivity checks sent
+			// but never acknowledged
+			if lost := int64(s.RequestsSent) - int64(s.ResponsesReceived); lost > 0 {
+				stats.PacketsLost = lost
+			}
+		}
+	}
+	return stats
+}
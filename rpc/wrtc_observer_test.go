@@ -0,0 +1,65 @@
+package rpc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestStatsFromReportOnlyUsesNominatedCandidatePair(t *testing.T) {
+	report := webrtc.StatsReport{
+		"candidate-pair-stale": webrtc.ICECandidatePairStats{
+			Nominated:            false,
+			CurrentRoundTripTime: 5,
+			RequestsSent:         100,
+			ResponsesReceived:    1,
+		},
+		"candidate-pair-selected": webrtc.ICECandidatePairStats{
+			Nominated:            true,
+			CurrentRoundTripTime: 0.05,
+			RequestsSent:         10,
+			ResponsesReceived:    8,
+		},
+		"data-channel-0": webrtc.DataChannelStats{
+			Label:         "data",
+			BytesSent:     1024,
+			BytesReceived: 2048,
+		},
+	}
+
+	stats := statsFromReport(report)
+
+	if stats.RTT != 50_000_000 { // 0.05s as a time.Duration
+		t.Fatalf("statsFromReport().RTT = %v, want the nominated pair's RTT", stats.RTT)
+	}
+	if stats.PacketsLost != 2 {
+		t.Fatalf("statsFromReport().PacketsLost = %d, want 2 from the nominated pair only", stats.PacketsLost)
+	}
+	if len(stats.DataChannels) != 1 || stats.DataChannels[0].Label != "data" ||
+		stats.DataChannels[0].BytesSent != 1024 || stats.DataChannels[0].BytesReceived != 2048 {
+		t.Fatalf("statsFromReport().DataChannels = %+v, want the single data channel stat", stats.DataChannels)
+	}
+}
+
+func TestStatsFromReportIgnoresPairsWithNoNomination(t *testing.T) {
+	report := webrtc.StatsReport{
+		"candidate-pair-stale-1": webrtc.ICECandidatePairStats{
+			Nominated:            false,
+			CurrentRoundTripTime: 1,
+			RequestsSent:         5,
+			ResponsesReceived:    1,
+		},
+		"candidate-pair-stale-2": webrtc.ICECandidatePairStats{
+			Nominated:            false,
+			CurrentRoundTripTime: 2,
+			RequestsSent:         5,
+			ResponsesReceived:    1,
+		},
+	}
+
+	stats := statsFromReport(report)
+
+	if stats.RTT != 0 || stats.PacketsLost != 0 {
+		t.Fatalf("statsFromReport() = %+v, want zero value with no nominated pair", stats)
+	}
+}